@@ -0,0 +1,290 @@
+package zipkintracer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/openzipkin/zipkin-go-opentracing/_thrift/gen-go/zipkincore"
+)
+
+// batchSender sends a batch of spans and reports whether it ultimately
+// succeeded. Implementations are responsible for their own encoding, retry,
+// and backoff policy; batchingCollector only decides when to call it and
+// what to do with the batch afterwards. ctx is context.Background() during
+// normal operation, and the CloseWithContext deadline once a shutdown is in
+// progress, so a retry loop can bail out of a backoff sleep (or an in-flight
+// request) as soon as the caller stops waiting.
+type batchSender func(ctx context.Context, spans []*zipkincore.Span) error
+
+// batchingCollector implements the batching, backlog, and graceful-shutdown
+// machinery shared by HTTPCollector and OTLPCollector: spans are buffered
+// until batchSize or batchInterval is hit, a single dedicated goroutine
+// performs the send so a slow or unreachable backend can't pile up
+// goroutines, and maxBacklog bounds memory use if sends keep failing.
+type batchingCollector struct {
+	logger        Logger
+	batchInterval time.Duration
+	batchSize     int
+	maxBacklog    int
+	nextSend      time.Time
+
+	send   batchSender
+	onDrop func(n int)
+
+	sendCtxMutex *sync.Mutex
+	sendCtx      context.Context
+
+	batch      []*zipkincore.Span
+	batchMutex *sync.Mutex
+	spanc      chan *zipkincore.Span
+	sendc      chan struct{}
+	senderQuit chan struct{}
+	senderDone chan struct{}
+	quit       chan context.Context
+	shutdown   chan error
+}
+
+// newBatchingCollector returns a batchingCollector with the package's
+// default batching parameters; callers (typically via their own options)
+// may override logger, batchInterval, batchSize, and maxBacklog before
+// calling start. send is invoked from a single goroutine to deliver the
+// current batch; onDrop, if non-nil, is called whenever spans are
+// discarded to respect maxBacklog.
+func newBatchingCollector(send batchSender, onDrop func(n int)) *batchingCollector {
+	c := &batchingCollector{
+		logger:        NewNopLogger(),
+		batchInterval: defaultHTTPBatchInterval * time.Second,
+		batchSize:     defaultBatchSize,
+		maxBacklog:    defaultMaxBacklog,
+		send:          send,
+		onDrop:        onDrop,
+		sendCtxMutex:  &sync.Mutex{},
+		sendCtx:       context.Background(),
+		batch:         []*zipkincore.Span{},
+		batchMutex:    &sync.Mutex{},
+		spanc:         make(chan *zipkincore.Span),
+		sendc:         make(chan struct{}, 1),
+		senderQuit:    make(chan struct{}),
+		senderDone:    make(chan struct{}),
+		quit:          make(chan context.Context, 1),
+		shutdown:      make(chan error, 1),
+	}
+	return c
+}
+
+// start begins the batching and sender goroutines. Call it once, after any
+// options have been applied.
+func (c *batchingCollector) start() {
+	c.scheduleNextSend()
+	go c.loop()
+	go c.sendLoop()
+}
+
+// Collect implements Collector.
+func (c *batchingCollector) Collect(s *zipkincore.Span) error {
+	c.spanc <- s
+	return nil
+}
+
+// Close implements Collector. It blocks until the current batch, plus
+// anything already queued on Collect, has been sent, with no deadline.
+func (c *batchingCollector) Close() error {
+	return c.CloseWithContext(context.Background())
+}
+
+// CloseWithContext drains any spans queued via Collect and flushes the
+// batch, retrying until it is empty or ctx is done, whichever comes first.
+// If ctx is done before the batch is empty, the remaining spans are
+// dropped and their count is returned in the error. This is useful for
+// short-lived jobs and serverless functions, where process exit follows
+// Close immediately and an unbounded drain could hang forever.
+func (c *batchingCollector) CloseWithContext(ctx context.Context) error {
+	c.quit <- ctx
+	return <-c.shutdown
+}
+
+func (c *batchingCollector) loop() {
+	ticker := time.NewTicker(c.batchInterval / 10)
+	defer ticker.Stop()
+	tickc := ticker.C
+
+	for {
+		select {
+		case span := <-c.spanc:
+			currentBatchSize := c.append(span)
+			if currentBatchSize >= c.batchSize {
+				c.scheduleNextSend()
+				c.requestSend()
+			}
+		case <-tickc:
+			if time.Now().After(c.nextSend) {
+				c.scheduleNextSend()
+				c.requestSend()
+			}
+		case ctx := <-c.quit:
+			// Store ctx before draining, so every send this shutdown triggers
+			// -- including ones dispatched by drain itself, not just the final
+			// flush sendLoop performs as it exits -- carries the deadline.
+			// Otherwise a send started during drain keeps retrying against
+			// context.Background() long after CloseWithContext has already
+			// given up and reported it dropped.
+			c.setSendCtx(ctx)
+
+			dropped := c.drain(ctx)
+			close(c.senderQuit)
+
+			select {
+			case <-c.senderDone:
+			case <-ctx.Done():
+				dropped = c.currentBatchSize()
+			}
+
+			var err error
+			if dropped > 0 {
+				err = fmt.Errorf("zipkin: dropped %d spans on close", dropped)
+			}
+			c.shutdown <- err
+			return
+		}
+	}
+}
+
+// drainPollInterval is how often drain re-checks whether the batch has
+// emptied out while waiting for the sender goroutine to flush it.
+const drainPollInterval = 10 * time.Millisecond
+
+// drain accepts any spans already queued on spanc, then repeatedly asks the
+// sender goroutine to flush the batch until it is empty or ctx is done. It
+// returns the number of spans left unsent when it gives up.
+func (c *batchingCollector) drain(ctx context.Context) (dropped int) {
+	for {
+		select {
+		case span := <-c.spanc:
+			c.append(span)
+			continue
+		default:
+		}
+		break
+	}
+
+	for c.currentBatchSize() > 0 {
+		select {
+		case <-ctx.Done():
+			return c.currentBatchSize()
+		default:
+		}
+
+		c.scheduleNextSend()
+		c.requestSend()
+
+		select {
+		case span := <-c.spanc:
+			c.append(span)
+		case <-time.After(drainPollInterval):
+		case <-ctx.Done():
+			return c.currentBatchSize()
+		}
+	}
+	return 0
+}
+
+// requestSend notifies the sender goroutine that a send is due. It never
+// blocks: if a send is already pending, this is a no-op, since the pending
+// send will pick up whatever has accumulated in the batch by the time it
+// runs.
+func (c *batchingCollector) requestSend() {
+	select {
+	case c.sendc <- struct{}{}:
+	default:
+	}
+}
+
+// sendLoop is the single goroutine allowed to call sendNow. Funneling all
+// sends through here bounds the number of in-flight sends to one, so a slow
+// or unreachable backend no longer causes goroutines to pile up.
+func (c *batchingCollector) sendLoop() {
+	for {
+		select {
+		case <-c.sendc:
+			c.sendNow()
+		case <-c.senderQuit:
+			c.sendNow()
+			close(c.senderDone)
+			return
+		}
+	}
+}
+
+func (c *batchingCollector) append(span *zipkincore.Span) (newBatchSize int) {
+	c.batchMutex.Lock()
+	defer c.batchMutex.Unlock()
+	c.batch = append(c.batch, span)
+	if len(c.batch) > c.maxBacklog {
+		dispose := len(c.batch) - c.maxBacklog
+		c.logger.Log("Backlog too long, disposing spans.", "count", dispose)
+		if c.onDrop != nil {
+			c.onDrop(dispose)
+		}
+		c.batch = c.batch[dispose:]
+	}
+	newBatchSize = len(c.batch)
+	return
+}
+
+// sendNow must only ever be called from sendLoop: it is the only goroutine
+// that reads and clears the batch once sent, so there is no need to guard
+// against concurrent sends here.
+func (c *batchingCollector) sendNow() {
+	// Select all current spans in the batch to be sent
+	c.batchMutex.Lock()
+	sendBatch := c.batch[:]
+	c.batchMutex.Unlock()
+
+	// Do not send an empty batch
+	if len(sendBatch) == 0 {
+		return
+	}
+
+	if err := c.send(c.getSendCtx(), sendBatch); err != nil {
+		c.logger.Log("err", err.Error())
+		// sendBatch is left in place at the head of c.batch (it was never
+		// removed below), so it is requeued for the next send attempt.
+		// Spans are only actually lost if append later disposes them to
+		// respect maxBacklog.
+		return
+	}
+
+	// Remove sent spans from the batch
+	c.batchMutex.Lock()
+	c.batch = c.batch[len(sendBatch):]
+	c.batchMutex.Unlock()
+}
+
+// setSendCtx replaces the context sendNow passes to send. Unlike an
+// atomic.Value, a plain mutex-guarded field tolerates context.Background()
+// and a context.WithTimeout/WithCancel/WithDeadline context being stored
+// across calls, which have different concrete types and would otherwise
+// panic atomic.Value.Store's "inconsistently typed value" check.
+func (c *batchingCollector) setSendCtx(ctx context.Context) {
+	c.sendCtxMutex.Lock()
+	defer c.sendCtxMutex.Unlock()
+	c.sendCtx = ctx
+}
+
+func (c *batchingCollector) getSendCtx() context.Context {
+	c.sendCtxMutex.Lock()
+	defer c.sendCtxMutex.Unlock()
+	return c.sendCtx
+}
+
+func (c *batchingCollector) currentBatchSize() int {
+	c.batchMutex.Lock()
+	defer c.batchMutex.Unlock()
+	return len(c.batch)
+}
+
+func (c *batchingCollector) scheduleNextSend() {
+	c.nextSend = time.Now().Add(c.batchInterval)
+}
@@ -0,0 +1,147 @@
+package zipkintracer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/openzipkin/zipkin-go-opentracing/_thrift/gen-go/zipkincore"
+)
+
+// v2Endpoint is the Zipkin v2 JSON representation of a zipkincore.Endpoint.
+type v2Endpoint struct {
+	ServiceName string `json:"serviceName,omitempty"`
+	IPv4        string `json:"ipv4,omitempty"`
+	IPv6        string `json:"ipv6,omitempty"`
+	Port        int32  `json:"port,omitempty"`
+}
+
+// v2Annotation is the Zipkin v2 JSON representation of a timestamped event.
+type v2Annotation struct {
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+// v2Span is the Zipkin v2 JSON representation of a zipkincore.Span, as
+// consumed by the /api/v2/spans endpoint.
+type v2Span struct {
+	TraceID        string            `json:"traceId"`
+	ParentID       string            `json:"parentId,omitempty"`
+	ID             string            `json:"id"`
+	Kind           string            `json:"kind,omitempty"`
+	Name           string            `json:"name,omitempty"`
+	Timestamp      int64             `json:"timestamp,omitempty"`
+	Duration       int64             `json:"duration,omitempty"`
+	Debug          bool              `json:"debug,omitempty"`
+	LocalEndpoint  *v2Endpoint       `json:"localEndpoint,omitempty"`
+	RemoteEndpoint *v2Endpoint       `json:"remoteEndpoint,omitempty"`
+	Annotations    []v2Annotation    `json:"annotations,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+}
+
+// HTTPJSONV2Encoder returns an HTTPBatchEncoder that serializes a batch of
+// spans using the Zipkin v2 JSON model instead of the legacy Thrift
+// encoding, for posting to endpoints such as Zipkin's /api/v2/spans or
+// Jaeger's Zipkin ingest.
+func HTTPJSONV2Encoder() HTTPBatchEncoder {
+	return func(spans []*zipkincore.Span) (*bytes.Buffer, string, error) {
+		v2Spans := make([]v2Span, len(spans))
+		for i, s := range spans {
+			v2Spans[i] = convertSpanToV2(s)
+		}
+
+		body, err := json.Marshal(v2Spans)
+		if err != nil {
+			return nil, "", err
+		}
+		return bytes.NewBuffer(body), "application/json", nil
+	}
+}
+
+func convertSpanToV2(s *zipkincore.Span) v2Span {
+	v2 := v2Span{
+		TraceID:  traceIDToV2(s),
+		ID:       idToV2(s.ID),
+		Name:     s.Name,
+		Debug:    s.Debug,
+		Duration: durationToV2(s),
+	}
+	if s.Timestamp != nil {
+		v2.Timestamp = *s.Timestamp
+	}
+	if s.ParentID != nil {
+		v2.ParentID = idToV2(*s.ParentID)
+	}
+
+	for _, a := range s.Annotations {
+		if endpoint := convertEndpointToV2(a.Host); endpoint != nil {
+			switch a.Value {
+			case zipkincore.CLIENT_SEND, zipkincore.CLIENT_RECV:
+				v2.Kind = "CLIENT"
+				v2.LocalEndpoint = endpoint
+				continue
+			case zipkincore.SERVER_RECV, zipkincore.SERVER_SEND:
+				v2.Kind = "SERVER"
+				v2.LocalEndpoint = endpoint
+				continue
+			}
+		}
+		v2.Annotations = append(v2.Annotations, v2Annotation{
+			Timestamp: a.Timestamp,
+			Value:     a.Value,
+		})
+	}
+
+	for _, ba := range s.BinaryAnnotations {
+		switch ba.Key {
+		case zipkincore.CLIENT_ADDR, zipkincore.SERVER_ADDR:
+			v2.RemoteEndpoint = convertEndpointToV2(ba.Host)
+		default:
+			if v2.Tags == nil {
+				v2.Tags = make(map[string]string)
+			}
+			v2.Tags[ba.Key] = string(ba.Value)
+		}
+	}
+
+	return v2
+}
+
+func convertEndpointToV2(e *zipkincore.Endpoint) *v2Endpoint {
+	if e == nil {
+		return nil
+	}
+	v2 := &v2Endpoint{
+		ServiceName: e.ServiceName,
+		Port:        int32(e.Port),
+	}
+	if e.Ipv4 != 0 {
+		v2.IPv4 = net.IPv4(byte(e.Ipv4>>24), byte(e.Ipv4>>16), byte(e.Ipv4>>8), byte(e.Ipv4)).String()
+	}
+	if len(e.Ipv6) > 0 {
+		v2.IPv6 = net.IP(e.Ipv6).String()
+	}
+	return v2
+}
+
+// traceIDToV2 renders the trace ID as 16 lowercase hex characters, or 32 if
+// the high bits are set (128-bit trace ID).
+func traceIDToV2(s *zipkincore.Span) string {
+	if s.TraceIDHigh != nil && *s.TraceIDHigh != 0 {
+		return fmt.Sprintf("%016x%016x", uint64(*s.TraceIDHigh), uint64(s.TraceID))
+	}
+	return idToV2(s.TraceID)
+}
+
+// idToV2 renders a span/parent ID as 16 lowercase hex characters.
+func idToV2(id int64) string {
+	return fmt.Sprintf("%016x", uint64(id))
+}
+
+func durationToV2(s *zipkincore.Span) int64 {
+	if s.Duration == nil {
+		return 0
+	}
+	return *s.Duration
+}
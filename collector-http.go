@@ -2,8 +2,10 @@ package zipkintracer
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/apache/thrift/lib/go/thrift"
@@ -21,21 +23,56 @@ const defaultBatchSize = 100
 
 const defaultMaxBacklog = 1000
 
+// defaultRetryMaxAttempts is the default number of times send is attempted
+// for a given batch, including the initial attempt. A value of 1 disables
+// retries.
+const defaultRetryMaxAttempts = 1
+
+// defaultRetryInitialBackoff is the default base delay before the first
+// retry; it doubles on each subsequent attempt.
+const defaultRetryInitialBackoff = 100 * time.Millisecond
+
+// HTTPBatchEncoder serializes a batch of spans into a request body and
+// reports the Content-Type to send it with. The default encoder,
+// httpThriftEncoder, writes a Thrift-encoded list of zipkincore.Span. Use
+// HTTPJSONV2Encoder to POST the Zipkin v2 JSON model instead, e.g. to
+// Zipkin's /api/v2/spans or Jaeger's Zipkin ingest.
+type HTTPBatchEncoder func(spans []*zipkincore.Span) (body *bytes.Buffer, contentType string, err error)
+
+func httpThriftEncoder(spans []*zipkincore.Span) (*bytes.Buffer, string, error) {
+	return httpSerialize(spans), "application/x-thrift", nil
+}
+
+// HTTPMetrics lets operators observe HTTPCollector's delivery health. Attempt
+// is called once per POST attempt (including retries), Success once per
+// batch that is accepted by the server, and Drop whenever spans are
+// discarded because maxBacklog was exceeded (e.g. a batch that never
+// manages to send while new spans keep arriving). The default,
+// nopHTTPMetrics, does nothing.
+type HTTPMetrics interface {
+	Attempt()
+	Success(spans int)
+	Drop(spans int)
+}
+
+type nopHTTPMetrics struct{}
+
+func (nopHTTPMetrics) Attempt()    {}
+func (nopHTTPMetrics) Success(int) {}
+func (nopHTTPMetrics) Drop(int)    {}
+
 // HTTPCollector implements Collector by forwarding spans to a http server.
 type HTTPCollector struct {
-	logger        Logger
-	url           string
-	client        *http.Client
-	nextSend      time.Time
-	batchInterval time.Duration
-	batchSize     int
-	maxBacklog    int
-	batch         []*zipkincore.Span
-	spanc         chan *zipkincore.Span
-	quit          chan struct{}
-	shutdown      chan error
-	sendMutex     *sync.Mutex
-	batchMutex    *sync.Mutex
+	*batchingCollector
+
+	url                 string
+	client              *http.Client
+	encoder             HTTPBatchEncoder
+	retryMaxAttempts    int
+	retryInitialBackoff time.Duration
+	retryableStatuses   map[int]bool
+	metrics             HTTPMetrics
+	requestCallback     func(*http.Request) error
 }
 
 // HTTPOption sets a parameter for the HttpCollector
@@ -72,46 +109,96 @@ func HTTPBatchInterval(d time.Duration) HTTPOption {
 	return func(c *HTTPCollector) { c.batchInterval = d }
 }
 
+// HTTPEncoder sets the encoder used to serialize a batch of spans into a
+// request body. The default serializes batches as a Thrift list of
+// zipkincore.Span with Content-Type application/x-thrift; pass
+// HTTPJSONV2Encoder to send the Zipkin v2 JSON model instead.
+func HTTPEncoder(encoder HTTPBatchEncoder) HTTPOption {
+	return func(c *HTTPCollector) { c.encoder = encoder }
+}
+
+// HTTPRetry sets the maximum number of attempts (including the first) made
+// to send a batch, and the base delay before the first retry. Each
+// subsequent retry doubles the delay and applies jitter. The default is 1
+// attempt, i.e. no retries.
+func HTTPRetry(maxAttempts int, initialBackoff time.Duration) HTTPOption {
+	return func(c *HTTPCollector) {
+		c.retryMaxAttempts = maxAttempts
+		c.retryInitialBackoff = initialBackoff
+	}
+}
+
+// HTTPRetryableStatuses sets the HTTP status codes that are treated as
+// transient and worth retrying. Network-level errors (connection refused,
+// timeouts, etc.) are always retried regardless of this setting. The
+// default, when unset, retries on 429 and any 5xx status.
+func HTTPRetryableStatuses(statuses []int) HTTPOption {
+	return func(c *HTTPCollector) {
+		c.retryableStatuses = make(map[int]bool, len(statuses))
+		for _, s := range statuses {
+			c.retryableStatuses[s] = true
+		}
+	}
+}
+
+// HTTPMetricsReporter sets the HTTPMetrics implementation used to observe
+// send attempts, successes, and drops. By default, no metrics are reported.
+func HTTPMetricsReporter(metrics HTTPMetrics) HTTPOption {
+	return func(c *HTTPCollector) { c.metrics = metrics }
+}
+
+// HTTPClient sets the http.Client used to perform requests, letting callers
+// configure a custom Transport, TLS settings, or proxy. HTTPTimeout sets the
+// Timeout of whichever client is configured when it is applied, so pass
+// HTTPClient before HTTPTimeout if you need both.
+func HTTPClient(client *http.Client) HTTPOption {
+	return func(c *HTTPCollector) { c.client = client }
+}
+
+// HTTPRequestCallback sets a hook invoked on every outgoing request just
+// before it is sent, after Content-Type has been set. It's the place to
+// inject auth tokens or other headers that may need to be refreshed between
+// batches; returning an error aborts the send attempt (and is subject to
+// the same retry handling as a failed POST).
+func HTTPRequestCallback(callback func(*http.Request) error) HTTPOption {
+	return func(c *HTTPCollector) { c.requestCallback = callback }
+}
+
+// HTTPHeaders is a shortcut for HTTPRequestCallback that sets a fixed set
+// of headers on every outgoing request, e.g. a bearer token or API key.
+func HTTPHeaders(headers http.Header) HTTPOption {
+	return HTTPRequestCallback(func(req *http.Request) error {
+		for k, vs := range headers {
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+		return nil
+	})
+}
+
 // NewHTTPCollector returns a new HTTP-backend Collector. url should be a http
 // url for handle post request. timeout is passed to http client. queueSize control
 // the maximum size of buffer of async queue. The logger is used to log errors,
 // such as send failures;
 func NewHTTPCollector(url string, options ...HTTPOption) (Collector, error) {
 	c := &HTTPCollector{
-		logger:        NewNopLogger(),
-		url:           url,
-		client:        &http.Client{Timeout: defaultHTTPTimeout},
-		batchInterval: defaultHTTPBatchInterval * time.Second,
-		batchSize:     defaultBatchSize,
-		maxBacklog:    defaultMaxBacklog,
-		batch:         []*zipkincore.Span{},
-		spanc:         make(chan *zipkincore.Span),
-		quit:          make(chan struct{}, 1),
-		shutdown:      make(chan error, 1),
-		sendMutex:     &sync.Mutex{},
-		batchMutex:    &sync.Mutex{},
+		url:                 url,
+		client:              &http.Client{Timeout: defaultHTTPTimeout},
+		encoder:             httpThriftEncoder,
+		retryMaxAttempts:    defaultRetryMaxAttempts,
+		retryInitialBackoff: defaultRetryInitialBackoff,
+		metrics:             nopHTTPMetrics{},
 	}
+	c.batchingCollector = newBatchingCollector(c.sendBatch, func(n int) { c.metrics.Drop(n) })
 
 	for _, option := range options {
 		option(c)
 	}
-	c.scheduleNextSend()
-	go c.loop()
+	c.start()
 	return c, nil
 }
 
-// Collect implements Collector.
-func (c *HTTPCollector) Collect(s *zipkincore.Span) error {
-	c.spanc <- s
-	return nil
-}
-
-// Close implements Collector.
-func (c *HTTPCollector) Close() error {
-	c.quit <- struct{}{}
-	return <-c.shutdown
-}
-
 func httpSerialize(spans []*zipkincore.Span) *bytes.Buffer {
 	t := thrift.NewTMemoryBuffer()
 	p := thrift.NewTBinaryProtocolTransport(t)
@@ -129,93 +216,98 @@ func httpSerialize(spans []*zipkincore.Span) *bytes.Buffer {
 	return t.Buffer
 }
 
-func (c *HTTPCollector) loop() {
-	ticker := time.NewTicker(c.batchInterval / 10)
-	defer ticker.Stop()
-	tickc := ticker.C
+// doSend performs a single POST attempt and reports the response status
+// code, or 0 if the request never got a response (e.g. a network error).
+func (c *HTTPCollector) doSend(ctx context.Context, spans []*zipkincore.Span) (status int, err error) {
+	body, contentType, err := c.encoder(spans)
+	if err != nil {
+		return 0, err
+	}
 
-	for {
-		var err error
-		select {
-		case span := <-c.spanc:
-			currentBatchSize := c.append(span)
-			if currentBatchSize >= c.batchSize {
-				c.scheduleNextSend()
-				go c.sendNow()
-			}
-		case <-tickc:
-			if time.Now().After(c.nextSend) {
-				c.scheduleNextSend()
-				go c.sendNow()
-			}
-		case <-c.quit:
-			c.sendNow()
-			c.shutdown <- err
-			return
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	if c.requestCallback != nil {
+		if err := c.requestCallback(req); err != nil {
+			return 0, err
 		}
 	}
-}
 
-func (c *HTTPCollector) append(span *zipkincore.Span) (newBatchSize int) {
-	c.batchMutex.Lock()
-	defer c.batchMutex.Unlock()
-	c.batch = append(c.batch, span)
-	if len(c.batch) > c.maxBacklog {
-		dispose := len(c.batch) - c.maxBacklog
-		c.logger.Log("Backlog too long, disposing spans.", "count", dispose)
-		c.batch = c.batch[dispose:]
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
 	}
-	newBatchSize = len(c.batch)
-	return
+	resp.Body.Close()
+	return resp.StatusCode, nil
 }
 
-func (c *HTTPCollector) send(spans []*zipkincore.Span) error {
-	req, err := http.NewRequest(
-		"POST",
-		c.url,
-		httpSerialize(spans))
+// retryable reports whether a failed attempt (status/err) is worth retrying.
+// Network-level errors are always retryable; for HTTP responses, it honors
+// HTTPRetryableStatuses, defaulting to 429 and any 5xx status.
+func (c *HTTPCollector) retryable(status int, err error) bool {
 	if err != nil {
-		return err
+		return true
 	}
-	req.Header.Set("Content-Type", "application/x-thrift")
-
-	_, err = c.client.Do(req)
-
-	return err
+	if c.retryableStatuses != nil {
+		return c.retryableStatuses[status]
+	}
+	return status == http.StatusTooManyRequests || status >= 500
 }
 
-func (c *HTTPCollector) sendNow() {
-	// in order to prevent sending the same batch twice
-	c.sendMutex.Lock()
-	defer c.sendMutex.Unlock()
-
-	// Select all current spans in the batch to be sent
-	c.batchMutex.Lock()
-	sendBatch := c.batch[:]
-	c.batchMutex.Unlock()
-
-	// Do not send an empty batch
-	if len(sendBatch) == 0 {
-		return
+// sendBatch is the batchSender passed to batchingCollector: it retries with
+// jittered exponential backoff and reports attempts/successes via metrics.
+// Drops are reported separately, by batchingCollector via onDrop, since
+// they happen in append rather than here. ctx bounds both the backoff sleep
+// and each POST attempt, so a CloseWithContext deadline stops a retry loop
+// promptly instead of running it to completion.
+func (c *HTTPCollector) sendBatch(ctx context.Context, spans []*zipkincore.Span) error {
+	backoff := c.retryInitialBackoff
+	var status int
+	var sendErr error
+	for attempt := 1; attempt <= c.retryMaxAttempts; attempt++ {
+		c.metrics.Attempt()
+
+		status, sendErr = c.doSend(ctx, spans)
+		if sendErr == nil && status >= 200 && status < 300 {
+			break
+		}
+		if attempt == c.retryMaxAttempts || !c.retryable(status, sendErr) {
+			break
+		}
+		if err := sleepOrDone(ctx, jitter(backoff)); err != nil {
+			return err
+		}
+		backoff *= 2
 	}
 
-	if err := c.send(sendBatch); err != nil {
-		c.logger.Log("err", err.Error())
-		return
+	if sendErr == nil && status >= 200 && status < 300 {
+		c.metrics.Success(len(spans))
+		return nil
 	}
-
-	// Remove sent spans from the batch
-	c.batchMutex.Lock()
-	c.batch = c.batch[len(sendBatch):]
-	c.batchMutex.Unlock()
+	if sendErr != nil {
+		return sendErr
+	}
+	return fmt.Errorf("unexpected http status %d", status)
 }
 
-func (c *HTTPCollector) currentBatchSize() int {
-	c.batchMutex.Lock()
-	defer c.batchMutex.Unlock()
-	return len(c.batch)
+// jitter returns d plus up to 20% random jitter, to avoid many collectors
+// retrying in lockstep against a recovering backend.
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
 }
 
-func (c *HTTPCollector) scheduleNextSend() {
-	c.nextSend = time.Now().Add(c.batchInterval)
+// sleepOrDone waits for d to elapse, returning early with ctx.Err() if ctx
+// is done first, so a backoff sleep doesn't outlive the caller's deadline.
+func sleepOrDone(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,162 @@
+package zipkintracer
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go-opentracing/_thrift/gen-go/zipkincore"
+)
+
+// stalledListener accepts TCP connections but never responds to any HTTP
+// request sent over them, simulating an unreachable or hung collector
+// backend. Unlike an httptest.Server with a slow handler, it never spawns a
+// goroutine per request on the server side, so any goroutine growth
+// observed by the tests below can only come from HTTPCollector itself.
+func stalledListener(t *testing.T) (addr string, closeFn func()) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// Accept the connection and then do nothing with it, so the
+			// client's request hangs until its own HTTPTimeout fires.
+			_ = conn
+		}
+	}()
+	return "http://" + ln.Addr().String(), func() { ln.Close() }
+}
+
+func someSpan() *zipkincore.Span {
+	id := int64(1)
+	return &zipkincore.Span{ID: id, TraceID: id, Name: "span"}
+}
+
+// TestHTTPCollectorBatchSizeBoundedGoroutines verifies that hammering
+// Collect against a backend that never responds does not leak goroutines:
+// HTTPBatchSize should trigger sends that queue up behind the single sender
+// goroutine, rather than spawning one goroutine per send attempt.
+func TestHTTPCollectorBatchSizeBoundedGoroutines(t *testing.T) {
+	addr, closeListener := stalledListener(t)
+	defer closeListener()
+
+	c, err := NewHTTPCollector(addr,
+		HTTPBatchSize(1),
+		HTTPTimeout(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("NewHTTPCollector: %v", err)
+	}
+	// Close has no deadline and the stalled backend never accepts the
+	// batch, so close with a bounded context instead.
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		c.(interface {
+			CloseWithContext(context.Context) error
+		}).CloseWithContext(ctx)
+	}()
+
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 200; i++ {
+		if err := c.Collect(someSpan()); err != nil {
+			t.Fatalf("Collect: %v", err)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	after := runtime.NumGoroutine()
+
+	if after > before+10 {
+		t.Fatalf("goroutine count grew unboundedly: before=%d after=%d", before, after)
+	}
+}
+
+// TestHTTPCollectorBatchIntervalBoundedGoroutines is the same assertion as
+// above, but relies on HTTPBatchInterval ticking over instead of
+// HTTPBatchSize being reached, since the two are independent triggers for a
+// send.
+func TestHTTPCollectorBatchIntervalBoundedGoroutines(t *testing.T) {
+	addr, closeListener := stalledListener(t)
+	defer closeListener()
+
+	c, err := NewHTTPCollector(addr,
+		HTTPBatchInterval(10*time.Millisecond),
+		HTTPTimeout(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("NewHTTPCollector: %v", err)
+	}
+	// Close has no deadline and the stalled backend never accepts the
+	// batch, so close with a bounded context instead.
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		c.(interface {
+			CloseWithContext(context.Context) error
+		}).CloseWithContext(ctx)
+	}()
+
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 50; i++ {
+		if err := c.Collect(someSpan()); err != nil {
+			t.Fatalf("Collect: %v", err)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	after := runtime.NumGoroutine()
+
+	if after > before+10 {
+		t.Fatalf("goroutine count grew unboundedly: before=%d after=%d", before, after)
+	}
+}
+
+// TestHTTPCollectorCloseWithContextDeadline confirms that CloseWithContext,
+// called with a real context.WithTimeout deadline (as opposed to Close's
+// context.Background()), neither panics nor blocks past that deadline, even
+// with HTTPRetry configured against a backend that never responds.
+func TestHTTPCollectorCloseWithContextDeadline(t *testing.T) {
+	addr, closeListener := stalledListener(t)
+	defer closeListener()
+
+	c, err := NewHTTPCollector(addr,
+		HTTPTimeout(1*time.Second),
+		HTTPRetry(5, 200*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("NewHTTPCollector: %v", err)
+	}
+
+	if err := c.Collect(someSpan()); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		c.(interface {
+			CloseWithContext(context.Context) error
+		}).CloseWithContext(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("CloseWithContext did not return within its deadline")
+	}
+}
@@ -0,0 +1,114 @@
+package zipkintracer
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/apache/thrift/lib/go/thrift"
+
+	"github.com/openzipkin/zipkin-go-opentracing/_thrift/gen-go/zipkincore"
+)
+
+// defaultKafkaTopic sets the standard kafka topic our spans are sent to.
+const defaultKafkaTopic = "zipkin"
+
+// KafkaCollector implements Collector by publishing spans to a Kafka
+// broker.
+type KafkaCollector struct {
+	producer       sarama.AsyncProducer
+	producerConfig *sarama.Config
+	logger         Logger
+	topic          string
+}
+
+// KafkaOption sets a parameter for the KafkaCollector
+type KafkaOption func(c *KafkaCollector)
+
+// KafkaLogger sets the logger used to report errors in the collection
+// process. By default, a no-op logger is used, i.e. no errors are logged
+// anywhere. It's important to set this option in a production service.
+func KafkaLogger(logger Logger) KafkaOption {
+	return func(c *KafkaCollector) { c.logger = logger }
+}
+
+// KafkaTopic sets the kafka topic to attach the collector producer on.
+func KafkaTopic(t string) KafkaOption {
+	return func(c *KafkaCollector) { c.topic = t }
+}
+
+// KafkaProducerConfig sets the sarama.Config used to create the collector's
+// AsyncProducer. If unset, a config with RequiredAcks set to WaitForLocal
+// and snappy compression enabled is used.
+func KafkaProducerConfig(config *sarama.Config) KafkaOption {
+	return func(c *KafkaCollector) { c.producerConfig = config }
+}
+
+// NewKafkaCollector returns a new Kafka-backed Collector. addrs should be a
+// slice of TCP endpoints of the form "host:port" for the Kafka brokers.
+func NewKafkaCollector(addrs []string, options ...KafkaOption) (Collector, error) {
+	c := &KafkaCollector{
+		logger: NewNopLogger(),
+		topic:  defaultKafkaTopic,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.producerConfig == nil {
+		c.producerConfig = sarama.NewConfig()
+		c.producerConfig.Producer.RequiredAcks = sarama.WaitForLocal
+		c.producerConfig.Producer.Compression = sarama.CompressionSnappy
+	}
+
+	producer, err := sarama.NewAsyncProducer(addrs, c.producerConfig)
+	if err != nil {
+		return nil, err
+	}
+	c.producer = producer
+
+	go c.logErrors()
+	go c.drainSuccesses()
+
+	return c, nil
+}
+
+func (c *KafkaCollector) logErrors() {
+	for pe := range c.producer.Errors() {
+		c.logger.Log("err", pe.Err.Error())
+	}
+}
+
+// drainSuccesses reads producer.Successes() for as long as the producer is
+// open. Sarama always creates this channel; it only fills up (and blocks
+// Input() forever once full) if a caller supplies a KafkaProducerConfig with
+// Producer.Return.Successes = true, but nothing here depends on that being
+// unset, so we drain it unconditionally.
+func (c *KafkaCollector) drainSuccesses() {
+	for range c.producer.Successes() {
+	}
+}
+
+// Collect implements Collector.
+func (c *KafkaCollector) Collect(s *zipkincore.Span) error {
+	c.producer.Input() <- &sarama.ProducerMessage{
+		Topic: c.topic,
+		Value: sarama.ByteEncoder(kafkaSerialize(s)),
+	}
+	return nil
+}
+
+// Close implements Collector.
+func (c *KafkaCollector) Close() error {
+	return c.producer.Close()
+}
+
+// kafkaSerialize encodes a single span as a standalone Thrift binary
+// struct, matching what the Zipkin Kafka consumer expects (as opposed to
+// httpSerialize's Thrift list encoding for a batch).
+func kafkaSerialize(s *zipkincore.Span) []byte {
+	t := thrift.NewTMemoryBuffer()
+	p := thrift.NewTBinaryProtocolTransport(t)
+	if err := s.Write(p); err != nil {
+		panic(err)
+	}
+	return t.Buffer.Bytes()
+}
@@ -0,0 +1,262 @@
+package zipkintracer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+
+	"github.com/openzipkin/zipkin-go-opentracing/_thrift/gen-go/zipkincore"
+)
+
+// defaultOTLPTimeout for http request in seconds
+const defaultOTLPTimeout = time.Second * 5
+
+// OTLPCollector implements Collector by converting spans to the OpenTelemetry
+// OTLP data model and forwarding them to an OTLP/HTTP endpoint, letting
+// existing users migrate gradually from Zipkin to OpenTelemetry collectors.
+type OTLPCollector struct {
+	*batchingCollector
+
+	url         string
+	client      *http.Client
+	serviceName string
+}
+
+// OTLPOption sets a parameter for the OTLPCollector
+type OTLPOption func(c *OTLPCollector)
+
+// OTLPLogger sets the logger used to report errors in the collection
+// process. By default, a no-op logger is used, i.e. no errors are logged
+// anywhere. It's important to set this option in a production service.
+func OTLPLogger(logger Logger) OTLPOption {
+	return func(c *OTLPCollector) { c.logger = logger }
+}
+
+// OTLPTimeout sets maximum timeout for http request.
+func OTLPTimeout(duration time.Duration) OTLPOption {
+	return func(c *OTLPCollector) { c.client.Timeout = duration }
+}
+
+// OTLPBatchSize sets the maximum batch size, after which a collect will be
+// triggered. The default batch size is 100 traces.
+func OTLPBatchSize(n int) OTLPOption {
+	return func(c *OTLPCollector) { c.batchSize = n }
+}
+
+// OTLPMaxBacklog sets the maximum backlog size, when batch size reaches
+// this threshold, spans from the beginning of the batch will be disposed.
+func OTLPMaxBacklog(n int) OTLPOption {
+	return func(c *OTLPCollector) { c.maxBacklog = n }
+}
+
+// OTLPBatchInterval sets the maximum duration we will buffer traces before
+// emitting them to the collector. The default batch interval is 1 second.
+func OTLPBatchInterval(d time.Duration) OTLPOption {
+	return func(c *OTLPCollector) { c.batchInterval = d }
+}
+
+// OTLPClient sets the http.Client used to perform requests, letting callers
+// configure a custom Transport, TLS settings, or proxy.
+func OTLPClient(client *http.Client) OTLPOption {
+	return func(c *OTLPCollector) { c.client = client }
+}
+
+// OTLPServiceName sets the resource-level service.name attribute reported
+// for spans whose CS/SR/SS/CR annotations' Host doesn't already carry one. It has
+// no default; if left unset and a span carries no host information either,
+// the span's resource attributes are omitted.
+func OTLPServiceName(name string) OTLPOption {
+	return func(c *OTLPCollector) { c.serviceName = name }
+}
+
+// NewOTLPCollector returns a new Collector that converts zipkincore.Span to
+// the OTLP data model and POSTs batches, Content-Type application/x-protobuf,
+// to url (e.g. "http://localhost:4318/v1/traces").
+func NewOTLPCollector(url string, options ...OTLPOption) (Collector, error) {
+	c := &OTLPCollector{
+		url:    url,
+		client: &http.Client{Timeout: defaultOTLPTimeout},
+	}
+	c.batchingCollector = newBatchingCollector(c.sendBatch, nil)
+
+	for _, option := range options {
+		option(c)
+	}
+	c.start()
+	return c, nil
+}
+
+func (c *OTLPCollector) sendBatch(ctx context.Context, spans []*zipkincore.Span) error {
+	req := &collectorpb.ExportTraceServiceRequest{
+		ResourceSpans: convertSpansToResourceSpans(spans, c.serviceName),
+	}
+
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp collector: unexpected http status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// convertSpansToResourceSpans groups spans by their host service name
+// (falling back to defaultServiceName) into one ResourceSpans per service,
+// since that's the unit OTLP exports attach resource attributes to.
+func convertSpansToResourceSpans(spans []*zipkincore.Span, defaultServiceName string) []*tracepb.ResourceSpans {
+	byService := make(map[string][]*tracepb.Span)
+	var order []string
+
+	for _, s := range spans {
+		name := hostServiceName(s)
+		if name == "" {
+			name = defaultServiceName
+		}
+		if _, ok := byService[name]; !ok {
+			order = append(order, name)
+		}
+		byService[name] = append(byService[name], convertSpanToOTLP(s))
+	}
+
+	out := make([]*tracepb.ResourceSpans, 0, len(order))
+	for _, name := range order {
+		var attrs []*commonpb.KeyValue
+		if name != "" {
+			attrs = []*commonpb.KeyValue{
+				{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: name}}},
+			}
+		}
+		out = append(out, &tracepb.ResourceSpans{
+			Resource: &resourcepb.Resource{Attributes: attrs},
+			ScopeSpans: []*tracepb.ScopeSpans{
+				{Spans: byService[name]},
+			},
+		})
+	}
+	return out
+}
+
+// hostServiceName returns the service name of the span's own (local)
+// endpoint, taken from its CS/SR/SS/CR annotations' Host, or "" if none is
+// present. Binary annotations such as CLIENT_ADDR/SERVER_ADDR are
+// deliberately not consulted here: per Zipkin v1 semantics, their Host
+// identifies the remote peer, not the span's own service, and mirrors the
+// LocalEndpoint/RemoteEndpoint split convertSpanToV2 makes.
+func hostServiceName(s *zipkincore.Span) string {
+	for _, a := range s.Annotations {
+		switch a.Value {
+		case zipkincore.CLIENT_SEND, zipkincore.CLIENT_RECV, zipkincore.SERVER_RECV, zipkincore.SERVER_SEND:
+			if a.Host != nil && a.Host.ServiceName != "" {
+				return a.Host.ServiceName
+			}
+		}
+	}
+	return ""
+}
+
+func convertSpanToOTLP(s *zipkincore.Span) *tracepb.Span {
+	span := &tracepb.Span{
+		TraceId:           traceIDBytes(s),
+		SpanId:            idBytes(s.ID),
+		Name:              s.Name,
+		Kind:              tracepb.Span_SPAN_KIND_INTERNAL,
+		StartTimeUnixNano: toUnixNano(s.Timestamp),
+		EndTimeUnixNano:   toUnixNano(endTimestamp(s)),
+	}
+	if s.ParentID != nil {
+		span.ParentSpanId = idBytes(*s.ParentID)
+	}
+
+	for _, a := range s.Annotations {
+		switch a.Value {
+		case zipkincore.CLIENT_SEND, zipkincore.CLIENT_RECV:
+			span.Kind = tracepb.Span_SPAN_KIND_CLIENT
+		case zipkincore.SERVER_RECV, zipkincore.SERVER_SEND:
+			span.Kind = tracepb.Span_SPAN_KIND_SERVER
+		default:
+			span.Events = append(span.Events, &tracepb.Span_Event{
+				TimeUnixNano: uint64(a.Timestamp) * uint64(time.Microsecond),
+				Name:         a.Value,
+			})
+		}
+	}
+
+	span.Status = &tracepb.Status{Code: tracepb.Status_STATUS_CODE_OK}
+	for _, ba := range s.BinaryAnnotations {
+		switch ba.Key {
+		case zipkincore.CLIENT_ADDR, zipkincore.SERVER_ADDR:
+			// The remote peer's address is skipped: it is not exported as
+			// an OTLP attribute or anywhere else on the span.
+			continue
+		case "error":
+			span.Status = &tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR, Message: string(ba.Value)}
+		default:
+			span.Attributes = append(span.Attributes, &commonpb.KeyValue{
+				Key:   ba.Key,
+				Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: string(ba.Value)}},
+			})
+		}
+	}
+
+	return span
+}
+
+func traceIDBytes(s *zipkincore.Span) []byte {
+	buf := make([]byte, 16)
+	if s.TraceIDHigh != nil {
+		putUint64(buf[0:8], uint64(*s.TraceIDHigh))
+	}
+	putUint64(buf[8:16], uint64(s.TraceID))
+	return buf
+}
+
+func idBytes(id int64) []byte {
+	buf := make([]byte, 8)
+	putUint64(buf, uint64(id))
+	return buf
+}
+
+func putUint64(buf []byte, v uint64) {
+	for i := 7; i >= 0; i-- {
+		buf[i] = byte(v)
+		v >>= 8
+	}
+}
+
+func toUnixNano(microsPtr *int64) uint64 {
+	if microsPtr == nil {
+		return 0
+	}
+	return uint64(*microsPtr) * uint64(time.Microsecond)
+}
+
+func endTimestamp(s *zipkincore.Span) *int64 {
+	if s.Timestamp == nil || s.Duration == nil {
+		return nil
+	}
+	end := *s.Timestamp + *s.Duration
+	return &end
+}